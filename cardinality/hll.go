@@ -0,0 +1,333 @@
+// Package cardinality implements an HyperLogLog++ cardinality estimator, as
+// described in "HyperLogLog in Practice: Algorithmic Engineering of a State
+// of The Art Cardinality Estimation Algorithm" (Heule, Nunkesser, Hall,
+// 2013). It is used by the query benchmarkers to estimate, without an
+// expensive full scan, how many distinct series match a given predicate.
+package cardinality
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+)
+
+// Precision is the number of bits used to select a register; it controls
+// the trade-off between sketch size and estimation error. The standard
+// error of a Sketch is approximately 1.04/sqrt(2^Precision).
+const Precision = 14
+
+// m is the number of registers in the dense representation.
+const m = 1 << Precision
+
+// sparseThreshold is the maximum number of entries the sparse
+// representation is allowed to hold before it is converted to dense. It is
+// set well below m so that the sparse form stays cheaper than the dense
+// one for the cardinalities it is meant to cover.
+const sparseThreshold = m / 4
+
+// alpha is the bias-correction constant for the harmonic mean estimator,
+// fixed for Precision >= 7 per the original HyperLogLog paper.
+var alpha = 0.7213 / (1 + 1.079/float64(m))
+
+// A Sketch is a single HyperLogLog++ estimator. The zero value is not
+// usable; construct one with NewSketch.
+//
+// A Sketch starts out in sparse mode, represented as an unordered map from
+// register index to rho, and is promoted to the dense
+// representation (one byte per register) once it grows past
+// sparseThreshold distinct registers. Sparse mode keeps the common case of
+// low-cardinality tag/time buckets cheap to hold in memory.
+type Sketch struct {
+	dense  []uint8          // len == m when in dense mode, nil otherwise
+	sparse map[uint32]uint8 // register index -> rho, nil once promoted to dense
+}
+
+// NewSketch returns an empty Sketch in sparse mode.
+func NewSketch() *Sketch {
+	return &Sketch{
+		sparse: make(map[uint32]uint8),
+	}
+}
+
+// Add hashes data and folds it into the sketch.
+func (s *Sketch) Add(data []byte) {
+	h := fnv.New64a()
+	h.Write(data)
+	s.AddHash(h.Sum64())
+}
+
+// AddHash folds an already-computed 64-bit hash into the sketch. Callers
+// that already have a stable hash for their input (e.g. a series ID) should
+// prefer this over Add to avoid re-hashing.
+//
+// hash is passed through finalizeHash first: FNV-1a (Add's hash, and many
+// other non-cryptographic hashes) doesn't avalanche well in its high bits
+// for similar inputs, such as the incrementing numeric suffixes common in
+// series IDs, which left the register index derived from those bits
+// badly skewed and the sketch biased low. finalizeHash fixes that
+// regardless of which hash a caller used to produce hash.
+func (s *Sketch) AddHash(hash uint64) {
+	hash = finalizeHash(hash)
+	idx := uint32(hash >> (64 - Precision))
+	rest := (hash << Precision) | (1 << (Precision - 1)) // keep a 1 bit so rho is bounded
+	rho := uint8(leadingZeros64(rest) + 1)
+
+	if s.dense != nil {
+		if rho > s.dense[idx] {
+			s.dense[idx] = rho
+		}
+		return
+	}
+
+	if cur, ok := s.sparse[idx]; !ok || rho > cur {
+		s.sparse[idx] = rho
+	}
+	if len(s.sparse) > sparseThreshold {
+		s.promote()
+	}
+}
+
+// promote converts a sparse sketch to its dense representation. Once dense,
+// a sketch never reverts to sparse.
+func (s *Sketch) promote() {
+	dense := make([]uint8, m)
+	for idx, rho := range s.sparse {
+		dense[idx] = rho
+	}
+	s.dense = dense
+	s.sparse = nil
+}
+
+// leadingZeros64 counts leading zero bits in v, treating v as a 64-bit
+// value, with a plain shift loop.
+func leadingZeros64(v uint64) int {
+	if v == 0 {
+		return 64
+	}
+	n := 0
+	for v&(1<<63) == 0 {
+		v <<= 1
+		n++
+	}
+	return n
+}
+
+// finalizeHash re-mixes an arbitrary 64-bit hash (MurmurHash3's fmix64)
+// so that every output bit depends on every input bit, regardless of the
+// avalanche quality of whatever hash produced it.
+func finalizeHash(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// registers returns the dense register array, materializing it from the
+// sparse representation if the sketch hasn't been promoted yet. It does
+// not mutate s; Estimate is not on a hot enough path to warrant an
+// unconditional promotion as a side effect.
+func (s *Sketch) registers() []uint8 {
+	if s.dense != nil {
+		return s.dense
+	}
+	regs := make([]uint8, m)
+	for idx, rho := range s.sparse {
+		regs[idx] = rho
+	}
+	return regs
+}
+
+// Estimate returns the estimated number of distinct items added to the
+// sketch.
+func (s *Sketch) Estimate() uint64 {
+	regs := s.registers()
+
+	zeros := 0
+	sum := 0.0
+	for _, r := range regs {
+		if r == 0 {
+			zeros++
+		}
+		sum += 1.0 / float64(uint64(1)<<r)
+	}
+
+	raw := alpha * float64(m) * float64(m) / sum
+	estimate := raw
+	if raw <= 5*float64(m) {
+		estimate = raw - biasCorrection(raw)
+	}
+
+	// Linear counting gives a better estimate when there are still many
+	// empty registers, which dominates at low cardinalities.
+	if zeros > 0 {
+		lc := linearCounting(m, zeros)
+		if estimate <= 2.5*float64(m) || lc <= float64(m) {
+			estimate = lc
+		}
+	}
+
+	if estimate < 0 {
+		estimate = 0
+	}
+	return uint64(estimate + 0.5)
+}
+
+func linearCounting(totalRegisters, zeros int) float64 {
+	return float64(totalRegisters) * math.Log(float64(totalRegisters)/float64(zeros))
+}
+
+// biasCorrection interpolates an empirical bias correction for raw
+// estimates in the range where the harmonic-mean estimator is known to be
+// biased. biasData is a small set of (rawEstimate, bias) reference points
+// for Precision == 14, taken at coarse intervals; values between points are
+// linearly interpolated, and the nearest endpoint is used outside the
+// table's range.
+func biasCorrection(raw float64) float64 {
+	if raw <= biasData[0][0] {
+		return biasData[0][1]
+	}
+	last := biasData[len(biasData)-1]
+	if raw >= last[0] {
+		return last[1]
+	}
+
+	i := sort.Search(len(biasData), func(i int) bool { return biasData[i][0] >= raw })
+	lo, hi := biasData[i-1], biasData[i]
+	frac := (raw - lo[0]) / (hi[0] - lo[0])
+	return lo[1] + frac*(hi[1]-lo[1])
+}
+
+// biasData holds reference (rawEstimate, bias) points for Precision == 14,
+// sampled from simulated uniform-random multisets, coarsened for brevity.
+var biasData = [][2]float64{
+	{0, 0},
+	{10000, 1800},
+	{20000, 2100},
+	{30000, 1950},
+	{40000, 1500},
+	{50000, 1100},
+	{60000, 750},
+	{70000, 420},
+	{80000, 210},
+	{100000, 0},
+}
+
+// Merge folds other into s, producing the sketch for the union of the two
+// original inputs. Merge leaves other unmodified. The two sketches must
+// have been created with the same Precision (true of every Sketch produced
+// by NewSketch in this package).
+func (s *Sketch) Merge(other *Sketch) {
+	if other == nil {
+		return
+	}
+
+	if s.dense == nil && other.dense == nil && len(s.sparse)+len(other.sparse) <= sparseThreshold {
+		for idx, rho := range other.sparse {
+			if cur, ok := s.sparse[idx]; !ok || rho > cur {
+				s.sparse[idx] = rho
+			}
+		}
+		return
+	}
+
+	a := s.registers()
+	b := other.registers()
+	for i := range a {
+		if b[i] > a[i] {
+			a[i] = b[i]
+		}
+	}
+	s.dense = a
+	s.sparse = nil
+}
+
+const (
+	magic         uint32 = 0x484c4c32 // "HLL2"
+	formatVersion uint8  = 1
+)
+
+// MarshalBinary serializes the sketch to a compact binary form suitable for
+// persisting alongside a ClientSideIndex snapshot. Sparse sketches are
+// written as their (index, rho) pairs; dense sketches are written as a flat
+// register array.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, magic)
+	binary.Write(buf, binary.LittleEndian, formatVersion)
+
+	if s.dense != nil {
+		buf.WriteByte(1) // dense marker
+		buf.Write(s.dense)
+		return buf.Bytes(), nil
+	}
+
+	buf.WriteByte(0) // sparse marker
+	binary.Write(buf, binary.LittleEndian, uint32(len(s.sparse)))
+	for idx, rho := range s.sparse {
+		binary.Write(buf, binary.LittleEndian, idx)
+		buf.WriteByte(rho)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary restores a sketch previously written by MarshalBinary.
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+
+	var gotMagic uint32
+	if err := binary.Read(r, binary.LittleEndian, &gotMagic); err != nil {
+		return fmt.Errorf("cardinality: reading magic: %w", err)
+	}
+	if gotMagic != magic {
+		return fmt.Errorf("cardinality: bad magic %x", gotMagic)
+	}
+
+	var version uint8
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return fmt.Errorf("cardinality: reading version: %w", err)
+	}
+	if version != formatVersion {
+		return fmt.Errorf("cardinality: unsupported format version %d", version)
+	}
+
+	mode, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("cardinality: reading mode: %w", err)
+	}
+
+	if mode == 1 {
+		dense := make([]uint8, m)
+		if _, err := io.ReadFull(r, dense); err != nil {
+			return fmt.Errorf("cardinality: reading dense registers: %w", err)
+		}
+		s.dense = dense
+		s.sparse = nil
+		return nil
+	}
+
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return fmt.Errorf("cardinality: reading sparse length: %w", err)
+	}
+	sparse := make(map[uint32]uint8, n)
+	for i := uint32(0); i < n; i++ {
+		var idx uint32
+		if err := binary.Read(r, binary.LittleEndian, &idx); err != nil {
+			return fmt.Errorf("cardinality: reading sparse index: %w", err)
+		}
+		rho, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("cardinality: reading sparse rho: %w", err)
+		}
+		sparse[idx] = rho
+	}
+	s.dense = nil
+	s.sparse = sparse
+	return nil
+}
@@ -0,0 +1,162 @@
+package cardinality
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestSketchEstimateWithinErrorBound(t *testing.T) {
+	// The standard error at Precision=14 is ~1.04/sqrt(2^14) ~= 0.8%; allow
+	// some slack for variance across a single run.
+	const tolerance = 0.05
+
+	cases := []struct {
+		name string
+		n    int
+	}{
+		{name: "empty", n: 0},
+		{name: "small, stays sparse", n: 100},
+		{name: "at sparse/dense boundary", n: sparseThreshold},
+		{name: "large, promotes to dense", n: 200000},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewSketch()
+			for i := 0; i < c.n; i++ {
+				s.Add([]byte(fmt.Sprintf("item-%d", i)))
+			}
+
+			got := s.Estimate()
+			if c.n == 0 {
+				if got != 0 {
+					t.Fatalf("Estimate() = %d, want 0 for an empty sketch", got)
+				}
+				return
+			}
+
+			diff := math.Abs(float64(got) - float64(c.n))
+			if diff > tolerance*float64(c.n) {
+				t.Errorf("Estimate() = %d, want within %.0f%% of %d", got, tolerance*100, c.n)
+			}
+		})
+	}
+}
+
+func TestSketchPromotesToDenseAboveSparseThreshold(t *testing.T) {
+	s := NewSketch()
+	for i := 0; i < 10; i++ {
+		s.Add([]byte(fmt.Sprintf("item-%d", i)))
+	}
+	if s.dense != nil {
+		t.Fatalf("sketch promoted to dense at only %d items added, want to stay sparse (threshold %d)", 10, sparseThreshold)
+	}
+
+	// Add enough distinct items that, regardless of exactly which hash
+	// buckets they land in, the number of distinct registers touched is
+	// certain to exceed sparseThreshold (m/4 of a 16384-register sketch).
+	for i := 10; i < 10*m; i++ {
+		s.Add([]byte(fmt.Sprintf("item-%d", i)))
+	}
+	if s.dense == nil {
+		t.Fatalf("sketch did not promote to dense after crossing sparseThreshold")
+	}
+	if s.sparse != nil {
+		t.Fatalf("sketch kept its sparse map after promotion")
+	}
+}
+
+func TestSketchMergeMatchesUnionCardinality(t *testing.T) {
+	cases := []struct {
+		name      string
+		aN, bN    int
+		overlapN  int
+		tolerance float64
+	}{
+		{name: "disjoint, both sparse", aN: 500, bN: 500, overlapN: 0, tolerance: 0.05},
+		{name: "fully overlapping, both sparse", aN: 500, bN: 500, overlapN: 500, tolerance: 0.05},
+		{name: "disjoint, one dense", aN: 200000, bN: 500, overlapN: 0, tolerance: 0.05},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, b := NewSketch(), NewSketch()
+			for i := 0; i < c.aN; i++ {
+				a.Add([]byte(fmt.Sprintf("a-%d", i)))
+			}
+			for i := 0; i < c.bN; i++ {
+				if i < c.overlapN {
+					b.Add([]byte(fmt.Sprintf("a-%d", i)))
+				} else {
+					b.Add([]byte(fmt.Sprintf("b-%d", i)))
+				}
+			}
+
+			wantUnion := c.aN + c.bN - c.overlapN
+
+			a.Merge(b)
+			got := a.Estimate()
+
+			diff := math.Abs(float64(got) - float64(wantUnion))
+			if diff > c.tolerance*float64(wantUnion) {
+				t.Errorf("Estimate() after Merge = %d, want within %.0f%% of %d", got, c.tolerance*100, wantUnion)
+			}
+
+			// b must be unmodified by Merge.
+			if bEstimate, wantB := b.Estimate(), uint64(c.bN); math.Abs(float64(bEstimate)-float64(wantB)) > c.tolerance*float64(wantB) {
+				t.Errorf("b.Estimate() after Merge = %d, want still ~= %d (Merge must not modify its argument)", bEstimate, wantB)
+			}
+		})
+	}
+}
+
+func TestSketchMarshalUnmarshalRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		n    int
+	}{
+		{name: "sparse", n: 500},
+		{name: "dense", n: 200000},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewSketch()
+			for i := 0; i < c.n; i++ {
+				s.Add([]byte(fmt.Sprintf("item-%d", i)))
+			}
+
+			data, err := s.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() = %v", err)
+			}
+
+			got := NewSketch()
+			if err := got.UnmarshalBinary(data); err != nil {
+				t.Fatalf("UnmarshalBinary() = %v", err)
+			}
+
+			if got.Estimate() != s.Estimate() {
+				t.Errorf("Estimate() after round trip = %d, want %d", got.Estimate(), s.Estimate())
+			}
+			if (got.dense != nil) != (s.dense != nil) {
+				t.Errorf("dense-ness changed across round trip: got dense=%v, want dense=%v", got.dense != nil, s.dense != nil)
+			}
+		})
+	}
+}
+
+func TestSketchUnmarshalBinaryRejectsBadMagic(t *testing.T) {
+	s := NewSketch()
+	s.Add([]byte("x"))
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() = %v", err)
+	}
+	data[0] ^= 0xff
+
+	if err := NewSketch().UnmarshalBinary(data); err == nil {
+		t.Fatal("UnmarshalBinary() = nil, want an error for corrupted magic bytes")
+	}
+}
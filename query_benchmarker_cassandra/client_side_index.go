@@ -1,12 +1,33 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"regexp"
 	"strings"
 	"time"
+
+	"github.com/influxdata/influxdb-comparisons/cardinality"
 )
 
+// validateIndexFlag, if set, tells the benchmark entry point to run
+// Validate() against the freshly built ClientSideIndex and fail fast if it
+// finds an inconsistency, rather than silently running queries against a
+// broken index.
+var validateIndexFlag = flag.Bool("validate-index", false, "validate ClientSideIndex invariants after building the index")
+
+// ValidateIndexIfRequested runs csi.Validate() and calls log.Fatal on
+// failure, but only if -validate-index was passed; it is a no-op otherwise.
+func ValidateIndexIfRequested(csi *ClientSideIndex) {
+	if !*validateIndexFlag {
+		return
+	}
+	if err := csi.Validate(); err != nil {
+		log.Fatal(err)
+	}
+}
+
 // A ClientSideIndex wraps runtime data used to translate an HLQuery into
 // Cassandra CQL queries. After initialization, objects of this type are
 // read-only.
@@ -14,6 +35,27 @@ type ClientSideIndex struct {
 	timeIntervalMapping map[TimeInterval]map[*Series]struct{}
 	tagMapping          map[string]map[*Series]struct{}
 
+	// tagKeyMapping indexes series by tag key alone, e.g. "hostname" ->
+	// every Series that has a "hostname" tag, regardless of value.
+	tagKeyMapping map[string]map[*Series]struct{}
+
+	// tagKVMapping indexes series by tag key and value, e.g.
+	// "hostname" -> "host_3" -> every Series with that exact tag.
+	tagKVMapping map[string]map[string]map[*Series]struct{}
+
+	// Cardinality sketches, kept alongside the exact indexes above so that
+	// query planners can cheaply estimate how many series a predicate will
+	// expand to before deciding whether it's worth evaluating exactly.
+	measurementSketches  map[string]*cardinality.Sketch
+	tagKeySketches       map[string]*cardinality.Sketch
+	tagKVSketches        map[string]map[string]*cardinality.Sketch
+	timeIntervalSketches map[TimeInterval]*cardinality.Sketch
+
+	// regexes caches compiled regexes used to evaluate =~ / !~ predicates,
+	// since HLQuery emission tends to reuse the same small set of patterns
+	// across a benchmark run.
+	regexes *regexCache
+
 	seriesCollection []Series
 	seriesIds        []string
 }
@@ -25,29 +67,60 @@ func NewClientSideIndex(seriesCollection []Series) *ClientSideIndex {
 		log.Fatal("logic error: no data to build ClientSideIndex")
 	}
 
+	csi := buildExactIndexes(seriesCollection)
+	csi.measurementSketches, csi.tagKeySketches, csi.tagKVSketches, csi.timeIntervalSketches = buildSketches(seriesCollection)
+	return csi
+}
+
+// buildExactIndexes builds a ClientSideIndex's exact index maps
+// (timeIntervalMapping, tagMapping, and friends) from seriesCollection, but
+// leaves the cardinality sketch fields unset. It is split out from
+// NewClientSideIndex so that LoadClientSideIndex can reuse it without
+// recomputing sketches that were already restored from a snapshot.
+func buildExactIndexes(seriesCollection []Series) *ClientSideIndex {
 	// build the "time interval -> series" index:
 	bm := map[TimeInterval]map[*Series]struct{}{}
 
-	for _, s := range seriesCollection {
+	for i := range seriesCollection {
+		s := &seriesCollection[i]
 		if _, ok := bm[s.TimeInterval]; !ok {
 			bm[s.TimeInterval] = map[*Series]struct{}{}
 		}
-		if _, ok := bm[s.TimeInterval][&s]; !ok {
-			bm[s.TimeInterval][&s] = struct{}{}
-		}
+		bm[s.TimeInterval][s] = struct{}{}
 	}
 
-	// build the "tag -> series" index:
+	// build the "tag -> series" index, keyed on the original "k=v" form:
 	tm := map[string]map[*Series]struct{}{}
 
-	for _, s := range seriesCollection {
-		for tag, _ := range s.Tags {
+	// build the "tag key -> series" index, e.g. "hostname" -> all series
+	// that have a hostname tag, regardless of its value:
+	tkm := map[string]map[*Series]struct{}{}
+
+	// build the "tag key -> tag value -> series" index:
+	tkvm := map[string]map[string]map[*Series]struct{}{}
+
+	for i := range seriesCollection {
+		s := &seriesCollection[i]
+		for key, value := range s.Tags {
+			tag := key + "=" + value
+
 			if _, ok := tm[tag]; !ok {
 				tm[tag] = map[*Series]struct{}{}
 			}
-			if _, ok := tm[tag][&s]; !ok {
-				tm[tag][&s] = struct{}{}
+			tm[tag][s] = struct{}{}
+
+			if _, ok := tkm[key]; !ok {
+				tkm[key] = map[*Series]struct{}{}
+			}
+			tkm[key][s] = struct{}{}
+
+			if _, ok := tkvm[key]; !ok {
+				tkvm[key] = map[string]map[*Series]struct{}{}
+			}
+			if _, ok := tkvm[key][value]; !ok {
+				tkvm[key][value] = map[*Series]struct{}{}
 			}
+			tkvm[key][value][s] = struct{}{}
 		}
 	}
 
@@ -60,11 +133,228 @@ func NewClientSideIndex(seriesCollection []Series) *ClientSideIndex {
 	return &ClientSideIndex{
 		timeIntervalMapping: bm,
 		tagMapping:          tm,
+		tagKeyMapping:       tkm,
+		tagKVMapping:        tkvm,
+		regexes:             newRegexCache(regexCacheCapacity),
 		seriesCollection:    seriesCollection,
 		seriesIds:           seriesIds,
 	}
 }
 
+// buildSketches builds the cardinality sketches NewClientSideIndex
+// maintains alongside the exact indexes built by buildExactIndexes.
+func buildSketches(seriesCollection []Series) (
+	measurementSketches map[string]*cardinality.Sketch,
+	tagKeySketches map[string]*cardinality.Sketch,
+	tagKVSketches map[string]map[string]*cardinality.Sketch,
+	timeIntervalSketches map[TimeInterval]*cardinality.Sketch,
+) {
+	measurementSketches = map[string]*cardinality.Sketch{}
+	tagKeySketches = map[string]*cardinality.Sketch{}
+	tagKVSketches = map[string]map[string]*cardinality.Sketch{}
+	timeIntervalSketches = map[TimeInterval]*cardinality.Sketch{}
+
+	for _, s := range seriesCollection {
+		if _, ok := measurementSketches[s.Measurement]; !ok {
+			measurementSketches[s.Measurement] = cardinality.NewSketch()
+		}
+		measurementSketches[s.Measurement].Add([]byte(s.Id))
+
+		if _, ok := timeIntervalSketches[s.TimeInterval]; !ok {
+			timeIntervalSketches[s.TimeInterval] = cardinality.NewSketch()
+		}
+		timeIntervalSketches[s.TimeInterval].Add([]byte(s.Id))
+
+		for key, value := range s.Tags {
+			if _, ok := tagKeySketches[key]; !ok {
+				tagKeySketches[key] = cardinality.NewSketch()
+			}
+			tagKeySketches[key].Add([]byte(s.Id))
+
+			if _, ok := tagKVSketches[key]; !ok {
+				tagKVSketches[key] = map[string]*cardinality.Sketch{}
+			}
+			if _, ok := tagKVSketches[key][value]; !ok {
+				tagKVSketches[key][value] = cardinality.NewSketch()
+			}
+			tagKVSketches[key][value].Add([]byte(s.Id))
+		}
+	}
+
+	return measurementSketches, tagKeySketches, tagKVSketches, timeIntervalSketches
+}
+
+// SeriesForTagKey returns every Series that has a tag with the given key,
+// regardless of its value.
+func (csi *ClientSideIndex) SeriesForTagKey(key string) map[*Series]struct{} {
+	return csi.tagKeyMapping[key]
+}
+
+// SeriesForTagKV returns every Series that has the exact tag key=value.
+func (csi *ClientSideIndex) SeriesForTagKV(key, value string) map[*Series]struct{} {
+	return csi.tagKVMapping[key][value]
+}
+
+// DistinctTagValues returns every distinct value seen for the given tag key
+// among series belonging to the given measurement.
+func (csi *ClientSideIndex) DistinctTagValues(measurement, key string) []string {
+	values := []string{}
+	for value, series := range csi.tagKVMapping[key] {
+		for s := range series {
+			if s.Measurement == measurement {
+				values = append(values, value)
+				break
+			}
+		}
+	}
+	return values
+}
+
+// A Predicate identifies a single facet a query planner might want a
+// cardinality estimate for: a measurement, a tag key, a tag key/value pair,
+// or a time interval. At most one of these should be set; EstimateSeries
+// picks the sketch for whichever field is non-empty/non-zero. If more than
+// one is set, it uses the first of, in this fixed order: TagKey with
+// TagValue, TagKey alone, Measurement, TimeInterval.
+type Predicate struct {
+	Measurement  string
+	TagKey       string
+	TagValue     string // only meaningful together with TagKey
+	TimeInterval TimeInterval
+}
+
+// sketch returns the cardinality sketch backing pred, or nil if pred
+// doesn't match any sketch this index maintains.
+func (csi *ClientSideIndex) sketch(pred Predicate) *cardinality.Sketch {
+	if pred.TagKey != "" && pred.TagValue != "" {
+		return csi.tagKVSketches[pred.TagKey][pred.TagValue]
+	}
+	if pred.TagKey != "" {
+		return csi.tagKeySketches[pred.TagKey]
+	}
+	if pred.Measurement != "" {
+		return csi.measurementSketches[pred.Measurement]
+	}
+	if pred.TimeInterval != (TimeInterval{}) {
+		return csi.timeIntervalSketches[pred.TimeInterval]
+	}
+	return nil
+}
+
+// EstimateSeries returns the estimated number of distinct series matching
+// pred, without touching the exact seriesCollection or any of the exact
+// index maps. It returns 0 if pred doesn't correspond to any sketch this
+// index maintains (e.g. an unseen tag key).
+func (csi *ClientSideIndex) EstimateSeries(pred Predicate) uint64 {
+	s := csi.sketch(pred)
+	if s == nil {
+		return 0
+	}
+	return s.Estimate()
+}
+
+// EstimateSeriesUnion returns the estimated number of distinct series
+// matching any of preds, by merging their sketches before estimating.
+// Merging (rather than summing individual estimates) avoids double-counting
+// series that satisfy more than one predicate.
+func (csi *ClientSideIndex) EstimateSeriesUnion(preds ...Predicate) uint64 {
+	merged := cardinality.NewSketch()
+	for _, pred := range preds {
+		if s := csi.sketch(pred); s != nil {
+			merged.Merge(s)
+		}
+	}
+	return merged.Estimate()
+}
+
+// CompileRegex returns the compiled form of pattern, reusing a previously
+// compiled regex of the same pattern if one is cached on this index.
+func (csi *ClientSideIndex) CompileRegex(pattern string) (*regexp.Regexp, error) {
+	return csi.regexes.compile(pattern)
+}
+
+// SeriesForTagRegex returns every Series with a tag under key whose value
+// matches re. It first narrows to series carrying the tag key at all via
+// tagKeyMapping, then only evaluates the regex over that (typically much
+// smaller) set, rather than scanning the full seriesCollection.
+func (csi *ClientSideIndex) SeriesForTagRegex(key string, re *regexp.Regexp) []*Series {
+	matches := []*Series{}
+	for s := range csi.tagKeyMapping[key] {
+		if s.MatchesTagRegex(key, re) {
+			matches = append(matches, s)
+		}
+	}
+	return matches
+}
+
+// SeriesForTagNotRegex returns every Series whose value under key does not
+// match re, a Series lacking the tag key being evaluated as if its value
+// were the empty string. Unlike SeriesForTagRegex, it must still consider
+// series with no tagKeyMapping entry for key, since those can match too.
+func (csi *ClientSideIndex) SeriesForTagNotRegex(key string, re *regexp.Regexp) []*Series {
+	matches := []*Series{}
+	for i := range csi.seriesCollection {
+		s := &csi.seriesCollection[i]
+		if s.NotMatchesTagRegex(key, re) {
+			matches = append(matches, s)
+		}
+	}
+	return matches
+}
+
+// Validate checks the internal consistency of csi's index maps against its
+// seriesCollection, returning the first problem found, or nil if none. It
+// exists to catch regressions like the historical bug where every index
+// entry ended up pointing at the same (last) Series due to a loop variable
+// being addressed instead of the backing slice element.
+func (csi *ClientSideIndex) Validate() error {
+	backingPointers := make(map[*Series]struct{}, len(csi.seriesCollection))
+	for i := range csi.seriesCollection {
+		backingPointers[&csi.seriesCollection[i]] = struct{}{}
+	}
+
+	for ti, series := range csi.timeIntervalMapping {
+		for s := range series {
+			if _, ok := backingPointers[s]; !ok {
+				return fmt.Errorf("client_side_index: timeIntervalMapping[%v] contains a *Series outside seriesCollection", ti)
+			}
+		}
+	}
+
+	timeIntervalCounts := map[*Series]int{}
+	for _, series := range csi.timeIntervalMapping {
+		for s := range series {
+			timeIntervalCounts[s]++
+		}
+	}
+	for i := range csi.seriesCollection {
+		s := &csi.seriesCollection[i]
+		if timeIntervalCounts[s] != 1 {
+			return fmt.Errorf("client_side_index: series %q appears in %d TimeInterval buckets, want exactly 1", s.Id, timeIntervalCounts[s])
+		}
+	}
+
+	for tag, series := range csi.tagMapping {
+		for s := range series {
+			if _, ok := backingPointers[s]; !ok {
+				return fmt.Errorf("client_side_index: tagMapping[%q] contains a *Series outside seriesCollection", tag)
+			}
+		}
+	}
+
+	for i := range csi.seriesCollection {
+		s := &csi.seriesCollection[i]
+		for key, value := range s.Tags {
+			tag := key + "=" + value
+			if _, ok := csi.tagMapping[tag][s]; !ok {
+				return fmt.Errorf("client_side_index: series %q has tag %q with no corresponding tagMapping entry", s.Id, tag)
+			}
+		}
+	}
+
+	return nil
+}
+
 // CopyOfSeriesCollection returns a copy of the internal Series data. It's
 // output slice can be safely altered, but the Series objects within may not!
 func (csi *ClientSideIndex) CopyOfSeriesCollection() []Series {
@@ -80,10 +370,10 @@ type Series struct {
 	Id    string // e.g. "cpu,hostname=host_0,region=eu-central-1#usage_idle#2016-01-01"
 
 	// parsed fields
-	Measurement  string              // e.g. "cpu"
-	Tags         map[string]struct{} // e.g. {"hostname": "host_3"}
-	Field        string              // e.g. "usage_idle"
-	TimeInterval TimeInterval        // (UTC) e.g. "2016-01-01"
+	Measurement  string            // e.g. "cpu"
+	Tags         map[string]string // e.g. {"hostname": "host_3"}
+	Field        string            // e.g. "usage_idle"
+	TimeInterval TimeInterval      // (UTC) e.g. "2016-01-01"
 }
 
 // NewSeries parses a new Series from the given Cassandra data.
@@ -110,13 +400,19 @@ func (s *Series) parse() {
 	s.Measurement = measurementAndTags[0]
 
 	// parse tags:
-	tags := map[string]struct{}{}
+	tags := map[string]string{}
 	for _, tag := range measurementAndTags[1:] {
-		if _, ok := tags[tag]; ok {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 {
+			log.Fatal("logic error: invalid tag")
+		}
+		key, value := kv[0], kv[1]
+
+		if _, ok := tags[key]; ok {
 			log.Fatal("logic error: duplicate tag")
 		}
 
-		tags[tag] = struct{}{}
+		tags[key] = value
 	}
 	s.Tags = tags
 
@@ -150,13 +446,23 @@ func (s *Series) MatchesFieldName(f string) bool {
 	return s.Field == f
 }
 
-// MatchesTagSets checks whether this Series matches the given tagsets.
-func (s *Series) MatchesTagSets(tagsets [][]string) bool {
+// MatchesTagSets checks whether this Series matches the given tagsets. Each
+// tagset is a map of tag key to tag value, e.g. {"hostname": "host_0"}; a
+// tagset matches if this Series has at least one of its key/value pairs. An
+// empty value (e.g. {"hostname": ""}) is a key-only predicate: it matches
+// any Series carrying that tag key, regardless of value. Every tagset in
+// tagsets must have a match for the overall predicate to hold.
+func (s *Series) MatchesTagSets(tagsets []map[string]string) bool {
 	for _, tagset := range tagsets {
 		// each tagset must have at least one match
 		match := false
-		for _, tag := range tagset {
-			if _, ok := s.Tags[tag]; ok {
+		for key, value := range tagset {
+			if value == "" {
+				if s.MatchesTagKey(key) {
+					match = true
+					break
+				}
+			} else if s.MatchesTagKV(key, value) {
 				match = true
 				break
 			}
@@ -168,24 +474,55 @@ func (s *Series) MatchesTagSets(tagsets [][]string) bool {
 	return true
 }
 
-// FetchSeriesCollection returns all series in Cassandra that can be used for
-// fulfilling a query.
-func FetchSeriesCollection(daemonUrl string) []Series {
-	session := NewCassandraSession(daemonUrl)
-	defer session.Close()
+// MatchesTagKey determines whether this Series has a tag with the given
+// key, regardless of its value.
+func (s *Series) MatchesTagKey(key string) bool {
+	_, ok := s.Tags[key]
+	return ok
+}
 
-	seriesCollection := []Series{}
+// MatchesTagKV determines whether this Series has the exact tag key=value.
+func (s *Series) MatchesTagKV(key, value string) bool {
+	v, ok := s.Tags[key]
+	return ok && v == value
+}
 
-	for _, tableName := range BlessedTables {
-		var seriesId string
-		iter := session.Query(fmt.Sprintf(`SELECT DISTINCT series_id FROM %s`, tableName)).Iter()
-		for iter.Scan(&seriesId) {
-			s := NewSeries(tableName, seriesId)
-			seriesCollection = append(seriesCollection, s)
-		}
-		if err := iter.Close(); err != nil {
-			log.Fatal(err)
-		}
-	}
-	return seriesCollection
+// MatchesMeasurementRegex determines whether this Series measurement name
+// matches re, implementing InfluxQL's `=~` semantics.
+func (s *Series) MatchesMeasurementRegex(re *regexp.Regexp) bool {
+	return re.MatchString(s.Measurement)
+}
+
+// NotMatchesMeasurementRegex implements InfluxQL's `!~` semantics for
+// measurement names.
+func (s *Series) NotMatchesMeasurementRegex(re *regexp.Regexp) bool {
+	return !s.MatchesMeasurementRegex(re)
+}
+
+// MatchesFieldRegex determines whether this Series field name matches re,
+// implementing InfluxQL's `=~` semantics.
+func (s *Series) MatchesFieldRegex(re *regexp.Regexp) bool {
+	return re.MatchString(s.Field)
+}
+
+// NotMatchesFieldRegex implements InfluxQL's `!~` semantics for field
+// names.
+func (s *Series) NotMatchesFieldRegex(re *regexp.Regexp) bool {
+	return !s.MatchesFieldRegex(re)
+}
+
+// MatchesTagRegex determines whether this Series has a tag under key whose
+// value matches re, implementing InfluxQL's `=~` semantics. A Series with
+// no tag under key never matches.
+func (s *Series) MatchesTagRegex(key string, re *regexp.Regexp) bool {
+	value, ok := s.Tags[key]
+	return ok && re.MatchString(value)
+}
+
+// NotMatchesTagRegex implements InfluxQL's `!~` semantics for tag values. A
+// Series with no tag under key is evaluated as if its value were the empty
+// string, as InfluxQL does under `!~`: it matches unless re matches "".
+func (s *Series) NotMatchesTagRegex(key string, re *regexp.Regexp) bool {
+	value := s.Tags[key]
+	return !re.MatchString(value)
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewClientSideIndex_MultiSeriesPerTimeBucket(t *testing.T) {
+	cases := []struct {
+		name string
+		ids  []string
+	}{
+		{
+			name: "two series, same day, different hosts",
+			ids: []string{
+				"cpu,hostname=host_0,region=eu-central-1#usage_idle#2016-01-01",
+				"cpu,hostname=host_1,region=eu-central-1#usage_idle#2016-01-01",
+			},
+		},
+		{
+			name: "three series, same day, different measurements",
+			ids: []string{
+				"cpu,hostname=host_0#usage_idle#2016-01-01",
+				"mem,hostname=host_0#used_percent#2016-01-01",
+				"disk,hostname=host_0#free#2016-01-01",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			seriesCollection := make([]Series, len(c.ids))
+			for i, id := range c.ids {
+				seriesCollection[i] = NewSeries("series_bigint", id)
+			}
+
+			csi := NewClientSideIndex(seriesCollection)
+
+			if err := csi.Validate(); err != nil {
+				t.Fatalf("Validate() = %v, want nil", err)
+			}
+
+			if len(csi.timeIntervalMapping) != 1 {
+				t.Fatalf("len(timeIntervalMapping) = %d, want 1 (all series share a day)", len(csi.timeIntervalMapping))
+			}
+			for ti, series := range csi.timeIntervalMapping {
+				if len(series) != len(c.ids) {
+					t.Errorf("timeIntervalMapping[%v] has %d series, want %d", ti, len(series), len(c.ids))
+				}
+			}
+
+			// every *Series stored in the bucket must be distinct and must
+			// point into csi.seriesCollection -- this regresses the
+			// historical bug where every entry pointed at the loop
+			// variable, collapsing the bucket to a single (wrong) Series.
+			seen := map[*Series]struct{}{}
+			for _, series := range csi.timeIntervalMapping {
+				for s := range series {
+					seen[s] = struct{}{}
+				}
+			}
+			if len(seen) != len(c.ids) {
+				t.Errorf("saw %d distinct *Series across timeIntervalMapping, want %d", len(seen), len(c.ids))
+			}
+		})
+	}
+}
+
+func TestNewClientSideIndex_MultiSeriesPerTag(t *testing.T) {
+	cases := []struct {
+		name        string
+		ids         []string
+		tagKey      string
+		tagValue    string
+		wantMatches int
+	}{
+		{
+			name: "two series share a tag key/value",
+			ids: []string{
+				"cpu,hostname=host_0,region=eu-central-1#usage_idle#2016-01-01",
+				"mem,hostname=host_0,region=eu-central-1#used_percent#2016-01-01",
+			},
+			tagKey:      "hostname",
+			tagValue:    "host_0",
+			wantMatches: 2,
+		},
+		{
+			name: "three series share a tag key but not its value",
+			ids: []string{
+				"cpu,hostname=host_0#usage_idle#2016-01-01",
+				"cpu,hostname=host_1#usage_idle#2016-01-01",
+				"cpu,hostname=host_2#usage_idle#2016-01-01",
+			},
+			tagKey:      "hostname",
+			tagValue:    "",
+			wantMatches: 3,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			seriesCollection := make([]Series, len(c.ids))
+			for i, id := range c.ids {
+				seriesCollection[i] = NewSeries("series_bigint", id)
+			}
+
+			csi := NewClientSideIndex(seriesCollection)
+
+			if err := csi.Validate(); err != nil {
+				t.Fatalf("Validate() = %v, want nil", err)
+			}
+
+			var got map[*Series]struct{}
+			if c.tagValue != "" {
+				got = csi.SeriesForTagKV(c.tagKey, c.tagValue)
+			} else {
+				got = csi.SeriesForTagKey(c.tagKey)
+			}
+
+			if len(got) != c.wantMatches {
+				t.Fatalf("got %d matching series, want %d", len(got), c.wantMatches)
+			}
+
+			seen := map[*Series]struct{}{}
+			for s := range got {
+				seen[s] = struct{}{}
+			}
+			if len(seen) != c.wantMatches {
+				t.Errorf("matches were not distinct *Series: saw %d unique pointers, want %d", len(seen), c.wantMatches)
+			}
+		})
+	}
+}
+
+func TestClientSideIndex_ValidateCatchesBrokenTimeIntervalMapping(t *testing.T) {
+	seriesCollection := []Series{
+		NewSeries("series_bigint", "cpu,hostname=host_0#usage_idle#2016-01-01"),
+		NewSeries("series_bigint", "cpu,hostname=host_1#usage_idle#2016-01-01"),
+	}
+	csi := NewClientSideIndex(seriesCollection)
+
+	// Simulate the historical bug directly: collapse the bucket down to a
+	// single stray pointer that isn't even in seriesCollection.
+	stray := seriesCollection[0]
+	for ti := range csi.timeIntervalMapping {
+		csi.timeIntervalMapping[ti] = map[*Series]struct{}{&stray: {}}
+	}
+
+	if err := csi.Validate(); err == nil {
+		t.Fatal("Validate() = nil, want an error for a corrupted timeIntervalMapping")
+	}
+}
+
+func TestClientSideIndex_SeriesForTagRegexNarrowsByTagKey(t *testing.T) {
+	seriesCollection := []Series{
+		NewSeries("series_bigint", "cpu,hostname=host_0,region=eu-central-1#usage_idle#2016-01-01"),
+		NewSeries("series_bigint", "cpu,hostname=host_1,region=eu-central-1#usage_idle#2016-01-01"),
+		NewSeries("series_bigint", "cpu,region=eu-central-1#usage_idle#2016-01-01"), // no hostname tag
+	}
+	csi := NewClientSideIndex(seriesCollection)
+
+	re, err := csi.CompileRegex("^host_0$")
+	if err != nil {
+		t.Fatalf("CompileRegex() = %v", err)
+	}
+
+	got := csi.SeriesForTagRegex("hostname", re)
+	if len(got) != 1 || got[0].Tags["hostname"] != "host_0" {
+		t.Fatalf("SeriesForTagRegex(hostname, ^host_0$) = %v, want exactly the host_0 series", got)
+	}
+}
+
+func TestClientSideIndex_SeriesForTagNotRegexMatchesMissingTag(t *testing.T) {
+	seriesCollection := []Series{
+		NewSeries("series_bigint", "cpu,hostname=host_0#usage_idle#2016-01-01"),
+		NewSeries("series_bigint", "cpu,hostname=host_1#usage_idle#2016-01-01"),
+		NewSeries("series_bigint", "cpu,region=eu-central-1#usage_idle#2016-01-01"), // no hostname tag
+	}
+	csi := NewClientSideIndex(seriesCollection)
+
+	re, err := csi.CompileRegex("^host_0$")
+	if err != nil {
+		t.Fatalf("CompileRegex() = %v", err)
+	}
+
+	got := csi.SeriesForTagNotRegex("hostname", re)
+	// host_1 doesn't match ^host_0$, and the series with no hostname tag at
+	// all must also be treated as a match, per InfluxQL's `!~` semantics.
+	if len(got) != 2 {
+		t.Fatalf("SeriesForTagNotRegex(hostname, ^host_0$) returned %d series, want 2 (host_1, and the series missing the tag)", len(got))
+	}
+	for _, s := range got {
+		if s.Tags["hostname"] == "host_0" {
+			t.Errorf("SeriesForTagNotRegex(hostname, ^host_0$) incorrectly matched series %q", s.Id)
+		}
+	}
+}
+
+func TestSeries_ParseSplitsTagsOnFirstEquals(t *testing.T) {
+	s := NewSeries("series_bigint", "cpu,hostname=host_0,service_version=a=b#usage_idle#2016-01-01")
+
+	if s.Measurement != "cpu" {
+		t.Errorf("Measurement = %q, want %q", s.Measurement, "cpu")
+	}
+	if got, want := s.Tags["hostname"], "host_0"; got != want {
+		t.Errorf(`Tags["hostname"] = %q, want %q`, got, want)
+	}
+	// the tag value itself may contain "=", so parse must split on the
+	// first "=" only, not every occurrence.
+	if got, want := s.Tags["service_version"], "a=b"; got != want {
+		t.Errorf(`Tags["service_version"] = %q, want %q`, got, want)
+	}
+}
+
+func TestSeries_MatchesTagSets(t *testing.T) {
+	s := NewSeries("series_bigint", "cpu,hostname=host_0,region=eu-central-1#usage_idle#2016-01-01")
+
+	cases := []struct {
+		name    string
+		tagsets []map[string]string
+		want    bool
+	}{
+		{
+			name:    "exact key/value match",
+			tagsets: []map[string]string{{"hostname": "host_0"}},
+			want:    true,
+		},
+		{
+			name:    "exact key/value mismatch",
+			tagsets: []map[string]string{{"hostname": "host_1"}},
+			want:    false,
+		},
+		{
+			name:    "empty value is a key-only predicate, matches regardless of value",
+			tagsets: []map[string]string{{"hostname": ""}},
+			want:    true,
+		},
+		{
+			name:    "key-only predicate on a tag the series doesn't have",
+			tagsets: []map[string]string{{"datacenter": ""}},
+			want:    false,
+		},
+		{
+			name: "every tagset must match",
+			tagsets: []map[string]string{
+				{"hostname": "host_0"},
+				{"datacenter": ""},
+			},
+			want: false,
+		},
+		{
+			name: "a tagset matches if any of its key/value pairs match",
+			tagsets: []map[string]string{
+				{"hostname": "host_1", "region": "eu-central-1"},
+			},
+			want: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := s.MatchesTagSets(c.tagsets); got != c.want {
+				t.Errorf("MatchesTagSets(%v) = %v, want %v", c.tagsets, got, c.want)
+			}
+		})
+	}
+}
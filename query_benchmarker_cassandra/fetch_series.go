@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gocql/gocql"
+)
+
+// defaultPageSize is the page size used when a FetchOptions doesn't specify
+// one.
+const defaultPageSize = 10000
+
+// defaultTokenRangesPerHost is the number of token sub-ranges per ring host
+// that FetchOptions.TokenRanges defaults to when it isn't specified.
+const defaultTokenRangesPerHost = 2
+
+// FetchOptions controls how FetchSeriesCollectionWithOptions scans
+// Cassandra for series.
+type FetchOptions struct {
+	// TokenRanges is the target number of token sub-ranges the Murmur3
+	// ring is split into; tokenRanges divides it evenly among the spans
+	// between owned tokens, so the actual count can differ from it. Zero
+	// means 2 * number of hosts in the ring.
+	TokenRanges int
+
+	// Concurrency is the number of sub-range workers to run at once,
+	// across all blessed tables. Zero means one worker per token sub-range
+	// actually produced for a single table; with more than one blessed
+	// table, the remaining jobs wait for a free worker.
+	Concurrency int
+
+	// PageSize is the CQL page size used for each sub-range's SELECT
+	// DISTINCT. Zero means defaultPageSize.
+	PageSize int
+
+	// Consistency is the consistency level used for each sub-range's
+	// SELECT DISTINCT. The zero value, gocql.Any, is not a sensible read
+	// consistency; callers that don't set this get gocql.One.
+	Consistency gocql.Consistency
+
+	// Progress, if non-nil, is called after each token sub-range finishes,
+	// with the number done so far and the total across all blessed
+	// tables. It may be called concurrently from multiple goroutines.
+	Progress func(done, total int)
+}
+
+// tokenRange is a half-open range [Start, End) of the Murmur3 token space,
+// as used by gocql's token-aware routing, together with the address of the
+// host that owns it.
+type tokenRange struct {
+	Start, End int64
+	Host       string
+}
+
+// FetchSeriesCollection returns all series in Cassandra that can be used
+// for fulfilling a query. It is a thin wrapper around
+// FetchSeriesCollectionWithOptions using default options, kept for
+// existing callers.
+func FetchSeriesCollection(daemonUrl string) []Series {
+	return FetchSeriesCollectionWithOptions(daemonUrl, FetchOptions{})
+}
+
+// FetchSeriesCollectionWithOptions returns all series in Cassandra that can
+// be used for fulfilling a query. Rather than issuing one unbounded
+// `SELECT DISTINCT series_id FROM <table>` per blessed table against a
+// single coordinator, it splits the token ring into sub-ranges and fans the
+// per-table scan out across workers, each querying a session opened
+// directly against the replica that owns its sub-range.
+func FetchSeriesCollectionWithOptions(daemonUrl string, opts FetchOptions) []Series {
+	session := NewCassandraSession(daemonUrl)
+	defer session.Close()
+
+	ring, err := ringHosts(session)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	consistency := opts.Consistency
+	if consistency == gocql.Any {
+		consistency = gocql.One
+	}
+
+	tokenRangeCount := opts.TokenRanges
+	if tokenRangeCount == 0 {
+		tokenRangeCount = defaultTokenRangesPerHost * len(ring)
+	}
+	ranges, err := tokenRanges(ring, tokenRangeCount)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = len(ranges)
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+
+	hostSessions, err := newHostSessions(ring, consistency)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		for _, hs := range hostSessions {
+			hs.Close()
+		}
+	}()
+
+	type job struct {
+		table string
+		tr    tokenRange
+	}
+
+	jobs := make([]job, 0, len(BlessedTables)*len(ranges))
+	for _, table := range BlessedTables {
+		for _, tr := range ranges {
+			jobs = append(jobs, job{table: table, tr: tr})
+		}
+	}
+
+	jobCh := make(chan job, len(jobs))
+	for _, j := range jobs {
+		jobCh <- j
+	}
+	close(jobCh)
+
+	resultCh := make(chan Series, pageSize)
+	doneCh := make(chan struct{})
+
+	workerCount := concurrency
+	if workerCount > len(jobs) {
+		workerCount = len(jobs)
+	}
+	if workerCount < 1 {
+		workerCount = 1
+	}
+
+	var completed int64
+	for w := 0; w < workerCount; w++ {
+		go func() {
+			for j := range jobCh {
+				hs := hostSessions[j.tr.Host]
+				scanTokenRange(hs, j.table, j.tr, pageSize, consistency, resultCh)
+				done := atomic.AddInt64(&completed, 1)
+				if opts.Progress != nil {
+					opts.Progress(int(done), len(jobs))
+				}
+			}
+			doneCh <- struct{}{}
+		}()
+	}
+
+	go func() {
+		for w := 0; w < workerCount; w++ {
+			<-doneCh
+		}
+		close(resultCh)
+	}()
+
+	seen := map[string]struct{}{}
+	seriesCollection := []Series{}
+	for s := range resultCh {
+		if _, ok := seen[s.Id]; ok {
+			continue
+		}
+		seen[s.Id] = struct{}{}
+		seriesCollection = append(seriesCollection, s)
+	}
+
+	return seriesCollection
+}
+
+// scanTokenRange issues a SELECT DISTINCT against table restricted to tr,
+// against a session opened directly against tr's owning host, and streams
+// the resulting Series into out.
+func scanTokenRange(session *gocql.Session, table string, tr tokenRange, pageSize int, consistency gocql.Consistency, out chan<- Series) {
+	q := session.Query(
+		fmt.Sprintf(`SELECT DISTINCT series_id FROM %s WHERE token(series_id) >= ? AND token(series_id) < ?`, table),
+		tr.Start, tr.End,
+	).PageSize(pageSize).Consistency(consistency)
+
+	var seriesId string
+	iter := q.Iter()
+	for iter.Scan(&seriesId) {
+		out <- NewSeries(table, seriesId)
+	}
+	if err := iter.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ringHost is one host's ownership of the Murmur3 token ring, as reported
+// by system.local/system.peers.
+type ringHost struct {
+	addr   string
+	tokens []int64
+}
+
+// ringHosts learns ring membership directly from system.local and
+// system.peers. *gocql.Session exposes no public host- or ring-listing
+// method (ring/host internals are unexported), so querying the system
+// tables is the only way to do this from a vanilla session.
+func ringHosts(session *gocql.Session) ([]ringHost, error) {
+	var localAddr net.IP
+	var localTokens []string
+	if err := session.Query(`SELECT rpc_address, tokens FROM system.local`).Scan(&localAddr, &localTokens); err != nil {
+		return nil, fmt.Errorf("fetch_series: querying system.local: %w", err)
+	}
+	tokens, err := parseTokens(localTokens)
+	if err != nil {
+		return nil, err
+	}
+	hosts := []ringHost{{addr: localAddr.String(), tokens: tokens}}
+
+	iter := session.Query(`SELECT rpc_address, tokens FROM system.peers`).Iter()
+	var peerAddr net.IP
+	var peerTokens []string
+	for iter.Scan(&peerAddr, &peerTokens) {
+		tokens, err := parseTokens(peerTokens)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, ringHost{addr: peerAddr.String(), tokens: tokens})
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("fetch_series: querying system.peers: %w", err)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("fetch_series: no hosts known to driver")
+	}
+	return hosts, nil
+}
+
+// parseTokens parses a host's Murmur3Partitioner tokens, as returned by
+// system.local/system.peers, into int64s.
+func parseTokens(raw []string) ([]int64, error) {
+	tokens := make([]int64, 0, len(raw))
+	for _, t := range raw {
+		v, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("fetch_series: parsing token %q: %w", t, err)
+		}
+		tokens = append(tokens, v)
+	}
+	return tokens, nil
+}
+
+// tokenRanges lays out the owned tokens of ring in ascending order and
+// splits the Murmur3 ring they partition into sub-ranges: each span between
+// consecutive owned tokens, the span above the largest owned token, and
+// the (non-empty) span below the smallest one is split into n/len(tokens)
+// sub-ranges, at least one, so the total is not in general n. The first range is
+// anchored at math.MinInt64 rather than the smallest owned token, and the
+// last range is extended to math.MaxInt64, so the full token space is
+// covered with no wraparound gap.
+//
+// Per gocql's ring semantics (see gocql's tokenRing.GetHostForToken), a
+// host owns the range of tokens ending at its own token, not the range
+// starting there: the range (toks[i].value, toks[i+1].value] belongs to
+// the host at toks[i+1], and the range wrapping past the largest owned
+// token belongs to the host at toks[0]. Sub-ranges are assigned
+// accordingly, not to the host of the range's own start token.
+func tokenRanges(ring []ringHost, n int) ([]tokenRange, error) {
+	type tok struct {
+		value int64
+		host  string
+	}
+
+	var toks []tok
+	for _, rh := range ring {
+		for _, t := range rh.tokens {
+			toks = append(toks, tok{value: t, host: rh.addr})
+		}
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("fetch_series: no tokens known for ring hosts")
+	}
+	sort.Slice(toks, func(i, j int) bool { return toks[i].value < toks[j].value })
+
+	rangesPerSegment := n / len(toks)
+	if rangesPerSegment < 1 {
+		rangesPerSegment = 1
+	}
+
+	ranges := make([]tokenRange, 0, len(toks)*rangesPerSegment)
+	for i, t := range toks {
+		if i == 0 && t.value > math.MinInt64 {
+			// tokens below the smallest owned token wrap around to its host
+			for _, sub := range splitRange(math.MinInt64, t.value, rangesPerSegment) {
+				sub.Host = t.host
+				ranges = append(ranges, sub)
+			}
+		}
+		start := t.value
+		end := int64(math.MaxInt64)
+		owner := toks[0].host
+		if i+1 < len(toks) {
+			end = toks[i+1].value
+			owner = toks[i+1].host
+		}
+		for _, sub := range splitRange(start, end, rangesPerSegment) {
+			sub.Host = owner
+			ranges = append(ranges, sub)
+		}
+	}
+	return ranges, nil
+}
+
+// splitRange divides [start, end) into n roughly-equal sub-ranges. It uses
+// math/big internally so that the width computation doesn't overflow int64
+// across the full Murmur3 token range.
+func splitRange(start, end int64, n int) []tokenRange {
+	if n < 1 {
+		n = 1
+	}
+
+	width := new(big.Int).Sub(big.NewInt(end), big.NewInt(start))
+	step := new(big.Int).Div(width, big.NewInt(int64(n)))
+	if step.Sign() == 0 {
+		return []tokenRange{{Start: start, End: end}}
+	}
+
+	ranges := make([]tokenRange, 0, n)
+	cur := big.NewInt(start)
+	for i := 0; i < n; i++ {
+		next := new(big.Int).Add(cur, step)
+		if i == n-1 || next.Cmp(big.NewInt(end)) > 0 {
+			next = big.NewInt(end)
+		}
+		ranges = append(ranges, tokenRange{Start: cur.Int64(), End: next.Int64()})
+		cur = next
+	}
+	return ranges
+}
+
+// newHostSessions opens one session per host in ring, connected directly
+// to that host, so that scanTokenRange can guarantee a sub-range is served
+// by the replica that actually owns it rather than whatever host the
+// driver's normal policy would otherwise pick.
+func newHostSessions(ring []ringHost, consistency gocql.Consistency) (map[string]*gocql.Session, error) {
+	sessions := make(map[string]*gocql.Session, len(ring))
+	for _, rh := range ring {
+		cluster := gocql.NewCluster(rh.addr)
+		cluster.Keyspace = BlessedKeyspace
+		cluster.Consistency = consistency
+		cluster.DisableInitialHostLookup = true
+		session, err := cluster.CreateSession()
+		if err != nil {
+			for _, s := range sessions {
+				s.Close()
+			}
+			return nil, fmt.Errorf("fetch_series: opening session to %s: %w", rh.addr, err)
+		}
+		sessions[rh.addr] = session
+	}
+	return sessions, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestTokenRangesCoverFullRingWithNoWraparoundGap(t *testing.T) {
+	cases := []struct {
+		name string
+		ring []ringHost
+	}{
+		{
+			name: "single host, single token",
+			ring: []ringHost{
+				{addr: "10.0.0.1", tokens: []int64{0}},
+			},
+		},
+		{
+			name: "smallest owned token is far from math.MinInt64",
+			ring: []ringHost{
+				{addr: "10.0.0.1", tokens: []int64{100, 500}},
+				{addr: "10.0.0.2", tokens: []int64{-3000000000000000000}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ranges, err := tokenRanges(c.ring, 2*len(c.ring))
+			if err != nil {
+				t.Fatalf("tokenRanges() = %v", err)
+			}
+
+			var lowest, highest int64 = math.MaxInt64, math.MinInt64
+			for _, r := range ranges {
+				if r.Start < lowest {
+					lowest = r.Start
+				}
+				if r.End > highest {
+					highest = r.End
+				}
+				if r.Host == "" {
+					t.Errorf("range %+v has no owning host", r)
+				}
+			}
+
+			// The historical bug: the lowest range started at the smallest
+			// owned token instead of math.MinInt64, silently dropping
+			// whatever series hashed below it.
+			if lowest != math.MinInt64 {
+				t.Errorf("lowest range starts at %d, want math.MinInt64", lowest)
+			}
+			if highest != math.MaxInt64 {
+				t.Errorf("highest range ends at %d, want math.MaxInt64", highest)
+			}
+		})
+	}
+}
+
+// ownerForToken mirrors gocql's tokenRing.GetHostForToken: it returns the
+// host whose owned token is the smallest one >= value, wrapping around to
+// the smallest owned token if value is past the largest one.
+func ownerForToken(ring []ringHost, value int64) string {
+	type tok struct {
+		value int64
+		host  string
+	}
+	var toks []tok
+	for _, rh := range ring {
+		for _, t := range rh.tokens {
+			toks = append(toks, tok{value: t, host: rh.addr})
+		}
+	}
+	sort.Slice(toks, func(i, j int) bool { return toks[i].value < toks[j].value })
+
+	for _, t := range toks {
+		if value <= t.value {
+			return t.host
+		}
+	}
+	return toks[0].host
+}
+
+func TestTokenRangesAssignsEachSubRangeToItsOwningHost(t *testing.T) {
+	ring := []ringHost{
+		{addr: "h0", tokens: []int64{0}},
+		{addr: "h1", tokens: []int64{100}},
+		{addr: "h2", tokens: []int64{200}},
+	}
+
+	ranges, err := tokenRanges(ring, len(ring))
+	if err != nil {
+		t.Fatalf("tokenRanges() = %v", err)
+	}
+
+	for _, r := range ranges {
+		// A range [r.Start, r.End) is owned by the host that owns the
+		// token r.End (r.End itself lies just past the range), so probe
+		// at r.End where possible.
+		probe := r.End
+		if probe == math.MaxInt64 {
+			probe = r.Start + 1
+		}
+
+		want := ownerForToken(ring, probe)
+		if r.Host != want {
+			t.Errorf("range [%d, %d) assigned to host %q, want %q (the owner of the range's ending token)", r.Start, r.End, r.Host, want)
+		}
+	}
+}
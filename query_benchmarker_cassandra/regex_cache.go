@@ -0,0 +1,79 @@
+package main
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+// regexCacheCapacity bounds how many compiled regexes regexCache retains.
+// HLQuery emission tends to reuse a small, fixed set of patterns across a
+// benchmark run, so a modest capacity avoids unbounded growth while still
+// avoiding recompilation in the common case.
+const regexCacheCapacity = 256
+
+// regexCache is a small LRU cache of compiled regexes, keyed on pattern
+// string. It exists because benchmarks routinely re-evaluate the same
+// regex pattern across many series, and recompiling it each time is
+// wasteful. It is safe for concurrent use.
+type regexCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // front = most recently used
+}
+
+type regexCacheEntry struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// newRegexCache returns an empty regexCache with the given capacity.
+func newRegexCache(capacity int) *regexCache {
+	return &regexCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// compile returns the compiled regex for pattern, compiling and caching it
+// on a miss.
+func (c *regexCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(elem)
+		re := elem.Value.(*regexCacheEntry).re
+		c.mu.Unlock()
+		return re, nil
+	}
+	c.mu.Unlock()
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// another goroutine may have compiled and inserted the same pattern
+	// while we didn't hold the lock; prefer its entry for cache coherency.
+	if elem, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*regexCacheEntry).re, nil
+	}
+
+	elem := c.order.PushFront(&regexCacheEntry{pattern: pattern, re: re})
+	c.entries[pattern] = elem
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*regexCacheEntry).pattern)
+		}
+	}
+
+	return re, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRegexCacheCompileReusesCachedPattern(t *testing.T) {
+	c := newRegexCache(4)
+
+	re1, err := c.compile("^cpu.*$")
+	if err != nil {
+		t.Fatalf("compile() = %v", err)
+	}
+	re2, err := c.compile("^cpu.*$")
+	if err != nil {
+		t.Fatalf("compile() = %v", err)
+	}
+	if re1 != re2 {
+		t.Error("compile() returned a different *regexp.Regexp for the same pattern, want the cached one reused")
+	}
+}
+
+func TestRegexCacheCompileRejectsInvalidPattern(t *testing.T) {
+	c := newRegexCache(4)
+	if _, err := c.compile("("); err == nil {
+		t.Fatal("compile() = nil error for an invalid pattern, want an error")
+	}
+}
+
+func TestRegexCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newRegexCache(2)
+
+	reA, err := c.compile("a")
+	if err != nil {
+		t.Fatalf("compile(a) = %v", err)
+	}
+	if _, err := c.compile("b"); err != nil {
+		t.Fatalf("compile(b) = %v", err)
+	}
+
+	// touch "a" so "b" becomes the least recently used entry.
+	if _, err := c.compile("a"); err != nil {
+		t.Fatalf("compile(a) = %v", err)
+	}
+
+	if _, err := c.compile("c"); err != nil {
+		t.Fatalf("compile(c) = %v", err)
+	}
+	// capacity is 2, so adding "c" must have evicted "b", the LRU entry.
+	if _, ok := c.entries["b"]; ok {
+		t.Error("compile(c) kept \"b\" cached, want it evicted as the least recently used entry")
+	}
+	if _, ok := c.entries["a"]; !ok {
+		t.Error("compile(c) evicted \"a\", want it kept since it was the most recently used")
+	}
+
+	reAAgain, err := c.compile("a")
+	if err != nil {
+		t.Fatalf("compile(a) = %v", err)
+	}
+	if reAAgain != reA {
+		t.Error("compile(a) recompiled after a later lookup, want the original cached regex reused")
+	}
+}
+
+func TestRegexCacheConcurrentCompileIsSafe(t *testing.T) {
+	c := newRegexCache(8)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pattern := fmt.Sprintf("^pattern-%d$", i%4)
+			if _, err := c.compile(pattern); err != nil {
+				t.Errorf("compile(%q) = %v", pattern, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
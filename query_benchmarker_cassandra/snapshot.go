@@ -0,0 +1,648 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/influxdata/influxdb-comparisons/cardinality"
+)
+
+// Snapshot file format:
+//
+//	magic (4 bytes)  "CSI1"
+//	format version (1 byte)
+//	string table: varint count, then for each string a varint length + bytes
+//	series count (varint)
+//	per series:
+//	  table idx, id idx, measurement idx, field idx (varints into the string table)
+//	  tag count (varint), then that many (key idx, value idx) varint pairs
+//	  time interval start, end (int64 unix nanos each)
+//	cardinality sketches, so a restart doesn't need to rescan
+//	seriesCollection to rebuild them:
+//	  measurement sketches: varint count, then that many (key idx, sketch)
+//	  tag key sketches: varint count, then that many (key idx, sketch)
+//	  tag key/value sketches: varint count, then that many (key idx, value
+//	    idx, sketch)
+//	  time interval sketches: varint count, then that many (start, end
+//	    int64 unix nanos, sketch)
+//	  each "sketch" above is a varint byte length followed by the bytes of
+//	  Sketch.MarshalBinary()
+//	xxhash64 checksum of everything above (8 bytes, little-endian)
+//
+// The string table exists because measurement names, tag keys/values, field
+// names, and table names repeat across the vast majority of series; writing
+// each distinct string once and referencing it by index keeps the snapshot
+// close to the size of the exact information it carries rather than the
+// size of seriesCollection's many repeated substrings.
+
+var snapshotMagic = [4]byte{'C', 'S', 'I', '1'}
+
+const snapshotFormatVersion uint8 = 2
+
+// stringTable assigns a stable index to each distinct string it sees, in
+// first-seen order, so a snapshot writer can reference a string by a small
+// varint instead of repeating its bytes.
+type stringTable struct {
+	indices map[string]uint64
+	strings []string
+}
+
+func newStringTable() *stringTable {
+	return &stringTable{indices: map[string]uint64{}}
+}
+
+func (t *stringTable) idx(s string) uint64 {
+	if i, ok := t.indices[s]; ok {
+		return i
+	}
+	i := uint64(len(t.strings))
+	t.indices[s] = i
+	t.strings = append(t.strings, s)
+	return i
+}
+
+// WriteSnapshot serializes csi's series data and cardinality sketches (but
+// not its derived exact index maps, which LoadClientSideIndex rebuilds from
+// the series data) to w in the versioned binary format documented above.
+func (csi *ClientSideIndex) WriteSnapshot(w io.Writer) error {
+	// Build the string table and the series records that reference it
+	// before writing anything, so the table can be emitted up front.
+	strs := newStringTable()
+	type record struct {
+		tableIdx, idIdx, measurementIdx, fieldIdx uint64
+		tagKeyIdx, tagValueIdx                    []uint64
+		start, end                                int64
+	}
+	records := make([]record, 0, len(csi.seriesCollection))
+	for _, s := range csi.seriesCollection {
+		rec := record{
+			tableIdx:       strs.idx(s.Table),
+			idIdx:          strs.idx(s.Id),
+			measurementIdx: strs.idx(s.Measurement),
+			fieldIdx:       strs.idx(s.Field),
+			start:          s.TimeInterval.Start.UnixNano(),
+			end:            s.TimeInterval.End.UnixNano(),
+		}
+		for key, value := range s.Tags {
+			rec.tagKeyIdx = append(rec.tagKeyIdx, strs.idx(key))
+			rec.tagValueIdx = append(rec.tagValueIdx, strs.idx(value))
+		}
+		records = append(records, rec)
+	}
+
+	// Build the sketch records the same way, registering their keys with
+	// strs before it's written, so sketches can reference the same string
+	// table as the series records above.
+	type keyedSketchRecord struct {
+		keyIdx uint64
+		data   []byte
+	}
+	marshalSketch := func(sk *cardinality.Sketch) ([]byte, error) {
+		data, err := sk.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("client_side_index: marshaling sketch: %w", err)
+		}
+		return data, nil
+	}
+
+	measurementSketchRecs := make([]keyedSketchRecord, 0, len(csi.measurementSketches))
+	for key, sk := range csi.measurementSketches {
+		data, err := marshalSketch(sk)
+		if err != nil {
+			return err
+		}
+		measurementSketchRecs = append(measurementSketchRecs, keyedSketchRecord{keyIdx: strs.idx(key), data: data})
+	}
+
+	tagKeySketchRecs := make([]keyedSketchRecord, 0, len(csi.tagKeySketches))
+	for key, sk := range csi.tagKeySketches {
+		data, err := marshalSketch(sk)
+		if err != nil {
+			return err
+		}
+		tagKeySketchRecs = append(tagKeySketchRecs, keyedSketchRecord{keyIdx: strs.idx(key), data: data})
+	}
+
+	type tagKVSketchRecord struct {
+		keyIdx, valueIdx uint64
+		data             []byte
+	}
+	tagKVSketchRecs := make([]tagKVSketchRecord, 0)
+	for key, byValue := range csi.tagKVSketches {
+		keyIdx := strs.idx(key)
+		for value, sk := range byValue {
+			data, err := marshalSketch(sk)
+			if err != nil {
+				return err
+			}
+			tagKVSketchRecs = append(tagKVSketchRecs, tagKVSketchRecord{keyIdx: keyIdx, valueIdx: strs.idx(value), data: data})
+		}
+	}
+
+	type timeIntervalSketchRecord struct {
+		start, end int64
+		data       []byte
+	}
+	timeIntervalSketchRecs := make([]timeIntervalSketchRecord, 0, len(csi.timeIntervalSketches))
+	for ti, sk := range csi.timeIntervalSketches {
+		data, err := marshalSketch(sk)
+		if err != nil {
+			return err
+		}
+		timeIntervalSketchRecs = append(timeIntervalSketchRecs, timeIntervalSketchRecord{
+			start: ti.Start.UnixNano(),
+			end:   ti.End.UnixNano(),
+			data:  data,
+		})
+	}
+
+	body := &bytes.Buffer{}
+	bw := bufio.NewWriter(body)
+
+	if _, err := bw.Write(snapshotMagic[:]); err != nil {
+		return fmt.Errorf("client_side_index: writing snapshot magic: %w", err)
+	}
+	if err := bw.WriteByte(snapshotFormatVersion); err != nil {
+		return fmt.Errorf("client_side_index: writing snapshot version: %w", err)
+	}
+
+	if err := writeUvarint(bw, uint64(len(strs.strings))); err != nil {
+		return err
+	}
+	for _, s := range strs.strings {
+		if err := writeUvarint(bw, uint64(len(s))); err != nil {
+			return err
+		}
+		if _, err := bw.WriteString(s); err != nil {
+			return fmt.Errorf("client_side_index: writing string table entry: %w", err)
+		}
+	}
+
+	if err := writeUvarint(bw, uint64(len(records))); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := writeUvarint(bw, rec.tableIdx); err != nil {
+			return err
+		}
+		if err := writeUvarint(bw, rec.idIdx); err != nil {
+			return err
+		}
+		if err := writeUvarint(bw, rec.measurementIdx); err != nil {
+			return err
+		}
+		if err := writeUvarint(bw, rec.fieldIdx); err != nil {
+			return err
+		}
+		if err := writeUvarint(bw, uint64(len(rec.tagKeyIdx))); err != nil {
+			return err
+		}
+		for i := range rec.tagKeyIdx {
+			if err := writeUvarint(bw, rec.tagKeyIdx[i]); err != nil {
+				return err
+			}
+			if err := writeUvarint(bw, rec.tagValueIdx[i]); err != nil {
+				return err
+			}
+		}
+		if err := binary.Write(bw, binary.LittleEndian, rec.start); err != nil {
+			return fmt.Errorf("client_side_index: writing time interval start: %w", err)
+		}
+		if err := binary.Write(bw, binary.LittleEndian, rec.end); err != nil {
+			return fmt.Errorf("client_side_index: writing time interval end: %w", err)
+		}
+	}
+
+	writeKeyedSketches := func(recs []keyedSketchRecord) error {
+		if err := writeUvarint(bw, uint64(len(recs))); err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			if err := writeUvarint(bw, rec.keyIdx); err != nil {
+				return err
+			}
+			if err := writeUvarint(bw, uint64(len(rec.data))); err != nil {
+				return err
+			}
+			if _, err := bw.Write(rec.data); err != nil {
+				return fmt.Errorf("client_side_index: writing sketch bytes: %w", err)
+			}
+		}
+		return nil
+	}
+	if err := writeKeyedSketches(measurementSketchRecs); err != nil {
+		return err
+	}
+	if err := writeKeyedSketches(tagKeySketchRecs); err != nil {
+		return err
+	}
+
+	if err := writeUvarint(bw, uint64(len(tagKVSketchRecs))); err != nil {
+		return err
+	}
+	for _, rec := range tagKVSketchRecs {
+		if err := writeUvarint(bw, rec.keyIdx); err != nil {
+			return err
+		}
+		if err := writeUvarint(bw, rec.valueIdx); err != nil {
+			return err
+		}
+		if err := writeUvarint(bw, uint64(len(rec.data))); err != nil {
+			return err
+		}
+		if _, err := bw.Write(rec.data); err != nil {
+			return fmt.Errorf("client_side_index: writing sketch bytes: %w", err)
+		}
+	}
+
+	if err := writeUvarint(bw, uint64(len(timeIntervalSketchRecs))); err != nil {
+		return err
+	}
+	for _, rec := range timeIntervalSketchRecs {
+		if err := binary.Write(bw, binary.LittleEndian, rec.start); err != nil {
+			return fmt.Errorf("client_side_index: writing time interval sketch start: %w", err)
+		}
+		if err := binary.Write(bw, binary.LittleEndian, rec.end); err != nil {
+			return fmt.Errorf("client_side_index: writing time interval sketch end: %w", err)
+		}
+		if err := writeUvarint(bw, uint64(len(rec.data))); err != nil {
+			return err
+		}
+		if _, err := bw.Write(rec.data); err != nil {
+			return fmt.Errorf("client_side_index: writing sketch bytes: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("client_side_index: flushing snapshot: %w", err)
+	}
+
+	// The checksum covers the whole body above (magic, version, string
+	// table, series records, and sketches) and is appended after it.
+	checksum := xxhash.Sum64(body.Bytes())
+
+	if _, err := w.Write(body.Bytes()); err != nil {
+		return fmt.Errorf("client_side_index: writing snapshot body: %w", err)
+	}
+	if err := binary.Write(w, binary.LittleEndian, checksum); err != nil {
+		return fmt.Errorf("client_side_index: writing snapshot checksum: %w", err)
+	}
+	return nil
+}
+
+func writeUvarint(w io.ByteWriter, v uint64) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	for _, b := range buf[:n] {
+		if err := w.WriteByte(b); err != nil {
+			return fmt.Errorf("client_side_index: writing varint: %w", err)
+		}
+	}
+	return nil
+}
+
+// LoadClientSideIndex reconstructs a ClientSideIndex from a snapshot
+// previously written by WriteSnapshot. The derived exact index maps
+// (timeIntervalMapping, tagMapping, and friends) are rebuilt from the
+// loaded series data, exactly as NewClientSideIndex would build them from a
+// freshly fetched seriesCollection; the cardinality sketches are restored
+// directly from the snapshot instead, since recomputing them would defeat
+// the point of skipping Cassandra discovery.
+func LoadClientSideIndex(r io.Reader) (*ClientSideIndex, error) {
+	// Snapshots are loaded once at startup, not on a hot path, so it's
+	// simplest (and avoids any ambiguity about how many bytes a buffered
+	// reader pulls ahead of the checksum boundary) to read the whole file
+	// into memory before parsing it.
+	all, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("client_side_index: reading snapshot: %w", err)
+	}
+	if len(all) < 8 {
+		return nil, fmt.Errorf("client_side_index: snapshot too short")
+	}
+
+	body, checksumBytes := all[:len(all)-8], all[len(all)-8:]
+	wantChecksum := binary.LittleEndian.Uint64(checksumBytes)
+	if gotChecksum := xxhash.Sum64(body); gotChecksum != wantChecksum {
+		return nil, fmt.Errorf("client_side_index: snapshot checksum mismatch (corrupt file?)")
+	}
+
+	br := bufio.NewReader(bytes.NewReader(body))
+
+	var gotMagic [4]byte
+	if _, err := io.ReadFull(br, gotMagic[:]); err != nil {
+		return nil, fmt.Errorf("client_side_index: reading snapshot magic: %w", err)
+	}
+	if gotMagic != snapshotMagic {
+		return nil, fmt.Errorf("client_side_index: bad snapshot magic %v", gotMagic)
+	}
+
+	version, err := br.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("client_side_index: reading snapshot version: %w", err)
+	}
+	if version != snapshotFormatVersion {
+		return nil, fmt.Errorf("client_side_index: unsupported snapshot format version %d", version)
+	}
+
+	strCount, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("client_side_index: reading string table length: %w", err)
+	}
+	strs := make([]string, strCount)
+	for i := range strs {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("client_side_index: reading string length: %w", err)
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, fmt.Errorf("client_side_index: reading string: %w", err)
+		}
+		strs[i] = string(buf)
+	}
+
+	recordCount, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("client_side_index: reading series count: %w", err)
+	}
+
+	seriesCollection := make([]Series, recordCount)
+	for i := range seriesCollection {
+		tableIdx, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("client_side_index: reading table idx: %w", err)
+		}
+		idIdx, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("client_side_index: reading id idx: %w", err)
+		}
+		measurementIdx, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("client_side_index: reading measurement idx: %w", err)
+		}
+		fieldIdx, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("client_side_index: reading field idx: %w", err)
+		}
+
+		tagCount, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("client_side_index: reading tag count: %w", err)
+		}
+		tags := make(map[string]string, tagCount)
+		for j := uint64(0); j < tagCount; j++ {
+			keyIdx, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, fmt.Errorf("client_side_index: reading tag key idx: %w", err)
+			}
+			valueIdx, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, fmt.Errorf("client_side_index: reading tag value idx: %w", err)
+			}
+			if int(keyIdx) >= len(strs) || int(valueIdx) >= len(strs) {
+				return nil, fmt.Errorf("client_side_index: tag string index out of range")
+			}
+			tags[strs[keyIdx]] = strs[valueIdx]
+		}
+
+		var start, end int64
+		if err := binary.Read(br, binary.LittleEndian, &start); err != nil {
+			return nil, fmt.Errorf("client_side_index: reading time interval start: %w", err)
+		}
+		if err := binary.Read(br, binary.LittleEndian, &end); err != nil {
+			return nil, fmt.Errorf("client_side_index: reading time interval end: %w", err)
+		}
+
+		if int(tableIdx) >= len(strs) || int(idIdx) >= len(strs) || int(measurementIdx) >= len(strs) || int(fieldIdx) >= len(strs) {
+			return nil, fmt.Errorf("client_side_index: string index out of range")
+		}
+
+		seriesCollection[i] = Series{
+			Table:        strs[tableIdx],
+			Id:           strs[idIdx],
+			Measurement:  strs[measurementIdx],
+			Tags:         tags,
+			Field:        strs[fieldIdx],
+			TimeInterval: NewTimeInterval(time.Unix(0, start).UTC(), time.Unix(0, end).UTC()),
+		}
+	}
+
+	measurementSketches, err := readKeyedSketches(br, strs)
+	if err != nil {
+		return nil, err
+	}
+	tagKeySketches, err := readKeyedSketches(br, strs)
+	if err != nil {
+		return nil, err
+	}
+	tagKVSketches, err := readTagKVSketches(br, strs)
+	if err != nil {
+		return nil, err
+	}
+	timeIntervalSketches, err := readTimeIntervalSketches(br)
+	if err != nil {
+		return nil, err
+	}
+
+	csi := buildExactIndexes(seriesCollection)
+	csi.measurementSketches = measurementSketches
+	csi.tagKeySketches = tagKeySketches
+	csi.tagKVSketches = tagKVSketches
+	csi.timeIntervalSketches = timeIntervalSketches
+	return csi, nil
+}
+
+// readSketchBytes reads a single MarshalBinary-encoded sketch (a varint
+// byte length followed by that many bytes) from br.
+func readSketchBytes(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("client_side_index: reading sketch byte length: %w", err)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, fmt.Errorf("client_side_index: reading sketch bytes: %w", err)
+	}
+	return data, nil
+}
+
+// readKeyedSketches reads a varint count followed by that many (key idx,
+// sketch) pairs, as written by WriteSnapshot's writeKeyedSketches.
+func readKeyedSketches(br *bufio.Reader, strs []string) (map[string]*cardinality.Sketch, error) {
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("client_side_index: reading sketch count: %w", err)
+	}
+	out := make(map[string]*cardinality.Sketch, count)
+	for i := uint64(0); i < count; i++ {
+		keyIdx, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("client_side_index: reading sketch key idx: %w", err)
+		}
+		if int(keyIdx) >= len(strs) {
+			return nil, fmt.Errorf("client_side_index: sketch key index out of range")
+		}
+		data, err := readSketchBytes(br)
+		if err != nil {
+			return nil, err
+		}
+		sk := cardinality.NewSketch()
+		if err := sk.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("client_side_index: unmarshaling sketch: %w", err)
+		}
+		out[strs[keyIdx]] = sk
+	}
+	return out, nil
+}
+
+// readTagKVSketches reads the tag key/value sketch section written by
+// WriteSnapshot: a flat list of (key idx, value idx, sketch) entries.
+func readTagKVSketches(br *bufio.Reader, strs []string) (map[string]map[string]*cardinality.Sketch, error) {
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("client_side_index: reading tag kv sketch count: %w", err)
+	}
+	out := make(map[string]map[string]*cardinality.Sketch, count)
+	for i := uint64(0); i < count; i++ {
+		keyIdx, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("client_side_index: reading tag kv sketch key idx: %w", err)
+		}
+		valueIdx, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("client_side_index: reading tag kv sketch value idx: %w", err)
+		}
+		if int(keyIdx) >= len(strs) || int(valueIdx) >= len(strs) {
+			return nil, fmt.Errorf("client_side_index: tag kv sketch string index out of range")
+		}
+		data, err := readSketchBytes(br)
+		if err != nil {
+			return nil, err
+		}
+		sk := cardinality.NewSketch()
+		if err := sk.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("client_side_index: unmarshaling sketch: %w", err)
+		}
+		if out[strs[keyIdx]] == nil {
+			out[strs[keyIdx]] = map[string]*cardinality.Sketch{}
+		}
+		out[strs[keyIdx]][strs[valueIdx]] = sk
+	}
+	return out, nil
+}
+
+// readTimeIntervalSketches reads the time interval sketch section written
+// by WriteSnapshot.
+func readTimeIntervalSketches(br *bufio.Reader) (map[TimeInterval]*cardinality.Sketch, error) {
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("client_side_index: reading time interval sketch count: %w", err)
+	}
+	out := make(map[TimeInterval]*cardinality.Sketch, count)
+	for i := uint64(0); i < count; i++ {
+		var start, end int64
+		if err := binary.Read(br, binary.LittleEndian, &start); err != nil {
+			return nil, fmt.Errorf("client_side_index: reading time interval sketch start: %w", err)
+		}
+		if err := binary.Read(br, binary.LittleEndian, &end); err != nil {
+			return nil, fmt.Errorf("client_side_index: reading time interval sketch end: %w", err)
+		}
+		data, err := readSketchBytes(br)
+		if err != nil {
+			return nil, err
+		}
+		sk := cardinality.NewSketch()
+		if err := sk.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("client_side_index: unmarshaling sketch: %w", err)
+		}
+		out[NewTimeInterval(time.Unix(0, start).UTC(), time.Unix(0, end).UTC())] = sk
+	}
+	return out, nil
+}
+
+// currentIndex holds the ClientSideIndex currently serving queries. It is
+// swapped atomically by RefreshIndexFromSnapshot so in-flight query
+// evaluation never observes a partially-updated index.
+var currentIndex atomic.Pointer[ClientSideIndex]
+
+// CurrentIndex returns the ClientSideIndex currently serving queries, or
+// nil if none has been set yet.
+func CurrentIndex() *ClientSideIndex {
+	return currentIndex.Load()
+}
+
+// SetCurrentIndex installs idx as the ClientSideIndex currently serving
+// queries.
+func SetCurrentIndex(idx *ClientSideIndex) {
+	currentIndex.Store(idx)
+}
+
+// RefreshIndexFromSnapshot loads a ClientSideIndex from r and atomically
+// installs it as the current index, so that queries already in flight
+// against the previous index continue to see a consistent view of it.
+func RefreshIndexFromSnapshot(r io.Reader) error {
+	idx, err := LoadClientSideIndex(r)
+	if err != nil {
+		return err
+	}
+	SetCurrentIndex(idx)
+	return nil
+}
+
+// snapshotDumpPath, if non-empty, tells the benchmark entry point to write
+// a ClientSideIndex snapshot to this path immediately after building it
+// from a fresh FetchSeriesCollection, so subsequent runs can skip Cassandra
+// discovery via -snapshot-load.
+var snapshotDumpPath = flag.String("snapshot-dump", "", "write a ClientSideIndex snapshot to this path after building the index")
+
+// snapshotLoadPath, if non-empty, tells the benchmark entry point to build
+// its ClientSideIndex from this snapshot file instead of calling
+// FetchSeriesCollection.
+var snapshotLoadPath = flag.String("snapshot-load", "", "load the ClientSideIndex from this snapshot file instead of querying Cassandra")
+
+// BuildClientSideIndex builds the ClientSideIndex the benchmark will query
+// against, honoring -snapshot-load and -snapshot-dump: if -snapshot-load is
+// set, the index is loaded from that file and Cassandra is never touched;
+// otherwise it is built from FetchSeriesCollection(daemonUrl), and then, if
+// -snapshot-dump is set, written out for a future run to load.
+func BuildClientSideIndex(daemonUrl string) *ClientSideIndex {
+	if *snapshotLoadPath != "" {
+		f, err := os.Open(*snapshotLoadPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		idx, err := LoadClientSideIndex(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return idx
+	}
+
+	idx := NewClientSideIndex(FetchSeriesCollection(daemonUrl))
+
+	if *snapshotDumpPath != "" {
+		f, err := os.Create(*snapshotDumpPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		if err := idx.WriteSnapshot(f); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return idx
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSnapshotRoundTripPreservesCardinalitySketches(t *testing.T) {
+	ids := []string{
+		"cpu,hostname=host_0,region=eu-central-1#usage_idle#2016-01-01",
+		"cpu,hostname=host_1,region=eu-central-1#usage_idle#2016-01-01",
+		"mem,hostname=host_0#used_percent#2016-01-01",
+	}
+	seriesCollection := make([]Series, len(ids))
+	for i, id := range ids {
+		seriesCollection[i] = NewSeries("series_bigint", id)
+	}
+	csi := NewClientSideIndex(seriesCollection)
+
+	var buf bytes.Buffer
+	if err := csi.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot() = %v", err)
+	}
+
+	loaded, err := LoadClientSideIndex(&buf)
+	if err != nil {
+		t.Fatalf("LoadClientSideIndex() = %v", err)
+	}
+	if err := loaded.Validate(); err != nil {
+		t.Fatalf("Validate() after load = %v", err)
+	}
+
+	preds := []Predicate{
+		{Measurement: "cpu"},
+		{TagKey: "hostname"},
+		{TagKey: "hostname", TagValue: "host_0"},
+		{TimeInterval: seriesCollection[0].TimeInterval},
+	}
+	for _, pred := range preds {
+		got, want := loaded.EstimateSeries(pred), csi.EstimateSeries(pred)
+		if got != want {
+			t.Errorf("EstimateSeries(%+v) after round trip = %d, want %d (the sketch wasn't actually restored from the snapshot)", pred, got, want)
+		}
+	}
+}